@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"math"
+	"net"
 	"runtime"
 	"strconv"
 	"strings"
@@ -34,6 +36,12 @@ type config struct {
 	tlsCert        string
 	tlsKey         string
 	tls            bool
+	pingMode       string // "raw" (default, needs root/CAP_NET_RAW) or "udp" (unprivileged ICMP over a UDP socket)
+	pingBindAddr   string // source address to bind ICMP probe sockets to; "" means the wildcard address
+	ping           bool   // also measure ICMP RTT to each host alongside the bandwidth test
+	pingInterval   string // ICMP probe interval; unspecified time unit defaults to second; "" keeps the prober's default
+	pingProbeType  string // "echo" (default) or "timestamp" (ICMP Timestamp Request/Reply, IPv4 only)
+	pingCsv        string // output filename for CSV exporting per-target ping latency; '%d' is the host's index in -hosts, '%s' is the host without port
 }
 
 func (h *hostList) String() string {
@@ -82,6 +90,12 @@ func main() {
 	flag.StringVar(&app.tlsCert, "cert", "cert.pem", "TLS cert file")
 	flag.BoolVar(&app.tls, "tls", false, "set to false to disable TLS")
 	flag.Uint64Var(&app.opt.totalFlow, "totalFlow", 0, "test bandwidth/latency by given total amount of data transmitted over each connection\ndata unit defaults to kB, totalDuration flag will be disabled")
+	flag.StringVar(&app.pingMode, "pingMode", "raw", "ICMP ping mode: 'raw' (requires root/CAP_NET_RAW) or 'udp' (unprivileged ICMP over a UDP socket, eg. Linux's ping_group_range)")
+	flag.StringVar(&app.pingBindAddr, "pingBindAddr", "", "source address to bind ICMP probe sockets to\nuseful on multi-homed hosts to pin probes to a specific interface\nempty means the wildcard address")
+	flag.BoolVar(&app.ping, "ping", false, "also measure ICMP RTT to each host alongside the bandwidth test")
+	flag.StringVar(&app.pingInterval, "pingInterval", "", "ICMP probe interval\nunspecified time unit defaults to second\nempty keeps the prober's default interval")
+	flag.StringVar(&app.pingProbeType, "pingProbeType", probeTypeEcho, "ICMP probe type: 'echo' (default) or 'timestamp' (ICMP Timestamp Request/Reply, IPv4 only)")
+	flag.StringVar(&app.pingCsv, "pingCsv", "", "output filename for CSV exporting per-target ping latency (separate from -csv, which is the bandwidth writer)\n'%d' is the host's index in -hosts\n'%s' is the host, without port\nexample: -pingCsv ping-%d-%s.csv")
 
 	flag.Parse()
 	if (app.silent) {
@@ -96,6 +110,10 @@ func main() {
 		log.Panicf("%s", errCsv.Error())
 	}
 
+	if errPingCsv := badExportFilename("-pingCsv", app.pingCsv); errPingCsv != nil {
+		log.Panicf("%s", errPingCsv.Error())
+	}
+
 	app.reportInterval = defaultTimeUnit(app.reportInterval)
 
 	app.totalDuration = defaultTimeUnit(app.totalDuration)
@@ -135,9 +153,97 @@ func main() {
 	}
 
 	log.Printf("client mode, %s protocol", proto)
+
+	if app.ping {
+		pingCtx, stopPing := context.WithCancel(context.Background())
+		defer stopPing()
+		startPingProbes(&app, pingCtx)
+	}
+
 	open(&app)
 }
 
+// startPingProbes launches one ICMP RTT Prober per host, running alongside the
+// bandwidth workers started by open(). Each prober's lifetime is bound to ctx, which
+// the caller cancels once the bandwidth run completes.
+//
+// Prober.Stats() (latency_ns/loss_pct/jitter) is not yet wired into the -export/-csv
+// writers or overlaid on the app.chart bandwidth graph; those renderers live outside
+// this file and aren't part of this tree. warnPingStatsUnsupported logs that gap
+// instead of silently dropping it when it would otherwise go unnoticed.
+func startPingProbes(app *config, ctx context.Context) {
+	warnPingStatsUnsupported(app)
+
+	if app.pingInterval != "" {
+		interval, err := time.ParseDuration(defaultTimeUnit(app.pingInterval))
+		if err != nil {
+			log.Panicf("bad pingInterval: %q: %v", app.pingInterval, err)
+		}
+		probeInterval = interval
+	}
+
+	probers := make([]*Prober, 0, len(app.hosts))
+	for i, host := range app.hosts {
+		prober := &Prober{}
+		cfg := ProberConfig{
+			source:    "client",
+			target:    pingTarget(host),
+			csv:       app.pingCsv,
+			connIndex: i,
+			pingMode:  app.pingMode,
+			bindAddr:  app.pingBindAddr,
+			probeType: app.pingProbeType,
+		}
+		if err := prober.Init(cfg); err != nil {
+			log.Printf("Cannot start ping probe for %s: %v\n", host, err)
+			continue
+		}
+		probers = append(probers, prober)
+		go prober.Start(ctx)
+	}
+
+	if len(probers) > 0 {
+		go reportPingSummaries(ctx, probers, app.opt.ReportInterval)
+	}
+}
+
+// reportPingSummaries logs each prober's rolling latency/loss Summary every interval,
+// next to the bandwidth client's own periodic reports, until ctx is cancelled.
+func reportPingSummaries(ctx context.Context, probers []*Prober, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, p := range probers {
+				s := p.Stats()
+				log.Printf("ping %s: loss=%.1f%% rtt(min/avg/max/p95/p99)=%s/%s/%s/%s/%s\n",
+					s.Target, s.LossPct, s.Min, s.Avg, s.Max, s.P95, s.P99)
+			}
+		}
+	}
+}
+
+// warnPingStatsUnsupported logs a visible warning when -ping is combined with a flag
+// whose output Prober.Stats() does not yet feed: ICMP latency/loss numbers are not
+// added to -export/-csv, and RTT is not overlaid on the app.chart bandwidth graph.
+func warnPingStatsUnsupported(app *config) {
+	if app.export != "" || app.csv != "" || app.chart != "" {
+		log.Printf("-ping: note that ICMP latency/loss stats are not yet added to -export/-csv output or the bandwidth chart; only the bandwidth test results are\n")
+	}
+}
+
+// pingTarget strips an optional ":port" suffix off a host[:port] entry, since ICMP
+// probes address the host directly
+func pingTarget(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
 // append "s" (second) to time string
 func defaultTimeUnit(s string) string {
 	if len(s) < 1 {
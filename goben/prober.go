@@ -6,44 +6,284 @@
 package main
 
 import (
+	"context"
 	"encoding/binary"
 	"encoding/csv"
 	"fmt"
 	"golang.org/x/net/icmp"
 	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 	"log"
+	"math"
 	"math/rand"
 	"net"
 	"os"
-	"os/signal"
+	"sort"
+	"strings"
 	"sync"
-	"syscall"
 	"time"
 )
 
 const (
 	timeBytesSize = 8
-	protocolICMP  = 1
-	probeInterval= 1000 * time.Millisecond // probe interval in milliseconds
+	protocolICMP  = 1  // IANA protocol number for ICMPv4, used by icmp.ParseMessage
+	protocolICMPv6= 58 // IANA protocol number for ICMPv6
+	protoICMPv4  = "ip4:icmp"      // network string for a raw ICMPv4 PacketConn
+	protoICMPv6  = "ip6:ipv6-icmp" // network string for a raw ICMPv6 PacketConn
 	pktInterval	= 300 * time.Millisecond // packet sending interval in milliseconds
 	pktsPerProbe = 3           // number of packets sent per probe
 	DEBUG = false
+	statsWindowSize = 100             // number of most recent RTT samples kept per target
 )
 
+// probeInterval is how often a Prober probes its target. It is a var, not a const, so
+// that the -pingInterval flag can override it before any Prober is started.
+var probeInterval = 1000 * time.Millisecond
+
+// lossTimeout is how long a probe run waits for its replies before declaring them lost.
+func lossTimeout() time.Duration {
+	return probeInterval * 3
+}
+
+// ping modes selectable via ProberConfig.pingMode / the -pingMode flag
+const (
+	pingModeRaw = "raw" // raw ICMP socket, requires root/CAP_NET_RAW
+	pingModeUDP = "udp" // unprivileged ICMP over a UDP socket (e.g. Linux's ping_group_range)
+)
+
+// icmpConns caches one sharedConn per network ("ip4:icmp", "ip6:ipv6-icmp", "udp4",
+// "udp6") and bind address, shared
+// across every Prober. This lets many Probers, each pinging a different (possibly mixed
+// IPv4/IPv6) target, probe concurrently while reusing a single socket per address family
+// instead of opening one per target. Mirrors the per-family socket cache used by
+// Tailscale's net/ping.
+var icmpConns = struct {
+	sync.Mutex
+	byProto map[string]*sharedConn
+}{byProto: make(map[string]*sharedConn)}
+
+// dialICMP returns the cached sharedConn for proto bound to bindAddr, opening one on
+// first use. bindAddr may be "" to bind the wildcard address.
+func dialICMP(proto, bindAddr string) (*sharedConn, error) {
+	icmpConns.Lock()
+	defer icmpConns.Unlock()
+	key := proto + "@" + bindAddr
+	if sc, ok := icmpConns.byProto[key]; ok {
+		return sc, nil
+	}
+	if bindAddr == "" {
+		bindAddr = wildcardAddr(proto)
+	}
+	conn, err := icmp.ListenPacket(proto, bindAddr)
+	if err != nil {
+		return nil, err
+	}
+	sc := newSharedConn(conn, familyForProto(proto))
+	icmpConns.byProto[key] = sc
+	return sc, nil
+}
+
+// wildcardAddr returns the "listen on everything" address for proto
+func wildcardAddr(proto string) string {
+	if proto == protoICMPv6 || proto == "udp6" {
+		return "::"
+	}
+	return "0.0.0.0"
+}
+
+// familyForProto returns the protocolICMP/protocolICMPv6 constant icmp.ParseMessage
+// needs to decode replies read off a conn dialed for proto.
+func familyForProto(proto string) int {
+	if proto == protoICMPv6 || proto == "udp6" {
+		return protocolICMPv6
+	}
+	return protocolICMP
+}
+
+// recvResult is one reply read off a sharedConn, or a terminal read error, delivered to
+// a subscriber.
+type recvResult struct {
+	senderIP net.IP
+	msg      *icmp.Message
+	err      error
+}
+
+// sharedConn wraps an *icmp.PacketConn that may be used by several Probers pinging
+// different targets of the same address family/bind address (see icmpConns). A single
+// goroutine owns the blocking ReadFrom loop and demultiplexes each reply to the
+// Prober(s) expecting it, keyed by sender IP.
+//
+// This replaces having every Prober's recv() call SetReadDeadline/ReadFrom directly on
+// the shared fd: SetReadDeadline applies to the whole fd, so with several Probers each
+// setting their own deadline on every probe tick, one Prober's deadline clobbered every
+// other Prober's, causing spurious early timeouts and misattributed loss/RTT.
+type sharedConn struct {
+	conn   *icmp.PacketConn
+	family int // protocolICMP or protocolICMPv6, passed to icmp.ParseMessage
+
+	mu        sync.Mutex
+	listeners map[string][]chan recvResult // keyed by sender IP string
+}
+
+func newSharedConn(conn *icmp.PacketConn, family int) *sharedConn {
+	sc := &sharedConn{conn: conn, family: family, listeners: make(map[string][]chan recvResult)}
+	go sc.readLoop()
+	return sc
+}
+
+// readLoop is the sole reader of sc.conn. It never sets a read deadline, so it blocks
+// until a reply arrives (or the conn is closed), and hands every reply to dispatch.
+func (sc *sharedConn) readLoop() {
+	pktbuf := make([]byte, 1500)
+	for {
+		n, sender, err := sc.conn.ReadFrom(pktbuf)
+		if err != nil {
+			sc.broadcast(recvResult{err: err})
+			return
+		}
+		msg, err := icmp.ParseMessage(sc.family, pktbuf[:n])
+		if err != nil {
+			if DEBUG {
+				log.Printf("Unmarshalling icmp message Error: %s\n", err.Error())
+			}
+			continue
+		}
+		sc.dispatch(recvResult{senderIP: addrIP(sender), msg: msg})
+	}
+}
+
+// dispatch delivers result to every subscriber registered for its sender IP. Subscriber
+// channels are buffered but non-blocking to send on: a slow subscriber drops the packet
+// rather than stalling readLoop (and every other Prober sharing this conn) behind it.
+func (sc *sharedConn) dispatch(result recvResult) {
+	sc.mu.Lock()
+	chans := sc.listeners[result.senderIP.String()]
+	sc.mu.Unlock()
+	for _, ch := range chans {
+		select {
+		case ch <- result:
+		default:
+		}
+	}
+}
+
+// broadcast delivers a terminal read error (e.g. the conn was closed) to every current
+// subscriber, since readLoop is about to exit and no further replies will be demultiplexed.
+func (sc *sharedConn) broadcast(result recvResult) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for _, chans := range sc.listeners {
+		for _, ch := range chans {
+			select {
+			case ch <- result:
+			default:
+			}
+		}
+	}
+}
+
+// subscribe registers a new listener for replies from targetIP and returns the channel
+// to read them from, plus an unsubscribe func the caller must call once done listening.
+func (sc *sharedConn) subscribe(targetIP string) (chan recvResult, func()) {
+	ch := make(chan recvResult, pktsPerProbe)
+	sc.mu.Lock()
+	sc.listeners[targetIP] = append(sc.listeners[targetIP], ch)
+	sc.mu.Unlock()
+	return ch, func() {
+		sc.mu.Lock()
+		defer sc.mu.Unlock()
+		chans := sc.listeners[targetIP]
+		for i, c := range chans {
+			if c == ch {
+				sc.listeners[targetIP] = append(chans[:i], chans[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// WriteTo sends b to addr on the shared conn. Writes carry no shared mutable state (no
+// deadline is ever set), so, unlike reads, concurrent Probers can safely call this
+// directly on the same underlying *icmp.PacketConn.
+func (sc *sharedConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return sc.conn.WriteTo(b, addr)
+}
+
+// addrIP extracts the IP from a net.Addr. In this codebase that addr is always a
+// *net.IPAddr (raw ping mode) or a *net.UDPAddr (UDP ping mode).
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.IPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+// isIPv6Target reports whether target is an IPv6 address. A trailing "%zone" (used to
+// scope IPv6 link-local addresses to an interface) is stripped first, since net.ParseIP
+// does not understand zone IDs.
+func isIPv6Target(target string) bool {
+	if idx := strings.IndexByte(target, '%'); idx >= 0 {
+		target = target[:idx]
+	}
+	ip := net.ParseIP(target)
+	return ip != nil && ip.To4() == nil
+}
+
+// protoForTarget returns the ICMP/UDP network string matching target's address family
+// and the prober's ping mode: a raw "ip4:icmp"/"ip6:ipv6-icmp" socket, or an unprivileged
+// "udp4"/"udp6" socket when pingMode is "udp"
+func protoForTarget(target, pingMode string) string {
+	v6 := isIPv6Target(target)
+	if pingMode == pingModeUDP {
+		if v6 {
+			return "udp6"
+		}
+		return "udp4"
+	}
+	if v6 {
+		return protoICMPv6
+	}
+	return protoICMPv4
+}
+
 type ProberConfig struct {
 	proto         string        // the protocol for the ICMP packet connection (ie. ip4:icmp, ip4:1, ip6:58 ...)
 	source        string        // the server address
 	target       string      	// the host' addresses
 	csv	  		  string		// if true, export the latency measurement to csv file
 	connIndex	  int			// parallel connection index to host
+	pingMode	  string		// "raw" (default, needs root/CAP_NET_RAW) or "udp" (unprivileged ICMP over a UDP socket)
+	bindAddr	  string		// source address to bind the probe socket to; "" means the wildcard address
+	probeType	  string		// "echo" (default) or "timestamp" (ICMP Timestamp Request/Reply, IPv4 only)
+}
+
+// probe types selectable via ProberConfig.probeType
+const (
+	probeTypeEcho      = "echo"
+	probeTypeTimestamp = "timestamp"
+)
+
+// probeType returns the prober's configured probe type, defaulting to probeTypeEcho
+func (p *Prober) probeType() string {
+	if p.config.probeType == "" {
+		return probeTypeEcho
+	}
+	return p.config.probeType
 }
 
 type Prober struct {
 	config		ProberConfig
-	conn		*icmp.PacketConn	// the ICMP connection
+	conn		*sharedConn			// the (possibly shared) ICMP connection
+	targetAddr	net.Addr			// the target, resolved once by listen()
+	recvCh		chan recvResult		// this prober's subscription on conn, set up by listen()
+	unsubscribe	func()				// releases recvCh's subscription on conn, called by close()
 	runCnt		uint64				// a counter that helps to construct seq# and runId
 	result		*csv.Writer			// the csv writer to record measurements
 	file		*os.File			// the csv file descriptor
+	stats		*latencyStats		// rolling per-target latency/loss aggregator, see Stats()
 }
 
 
@@ -52,28 +292,20 @@ type Prober struct {
 func (p *Prober) Init(config ProberConfig) error {
 	p.config = config
 	validateProberConfig()
+	p.stats = newLatencyStats()
 	// prepare csv writer
 	if p.config.csv != "" {
 		filePath := fmt.Sprintf(p.config.csv, p.config.connIndex, p.config.target)
 		header := []string{"dst", "rtt"}
+		if p.probeType() == probeTypeTimestamp {
+			header = append(header, "fwd_delay_ns", "rev_delay_ns")
+		}
 		writer, file, err := openCSV(filePath, header)
 		if err != nil {
 			log.Panicf("Cannot create a logging file to persist network traffic statistics! %v\n", err.Error())
 		}
 		p.result = writer
 		p.file = file
-
-		// gracefully handle file closing
-		c := make(chan os.Signal, 2)
-		signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
-		go func() {
-			<-c
-			err := closeCSV(p.result, file)
-			if err != nil {
-				log.Printf("Cannot close csv file. %v\n", err.Error())
-			}
-			os.Exit(1)
-		}()
 	}
 
 	return p.listen()
@@ -89,14 +321,41 @@ func validateProberConfig() {
 }
 
 // create a new icmp connection to listen to in coming packets
+// the protocol (ip4:icmp vs ip6:ipv6-icmp) is derived from the target address
+// so that IPv4 and IPv6 hosts are both probed correctly
 func (p *Prober) listen() error {
 	opts := p.config
-	if opts.proto == "" {
+	if opts.target == "" {
 		log.Fatalf("The prober from host %s misses configuration info.\n", opts.source)
 	}
-	var err error
-	p.conn, err = icmp.ListenPacket(opts.proto, "0.0.0.0") // use 0.0.0.0 here meaning we listen to any packets regardless if the packet is addressed to myself
-	return err
+	if opts.pingMode == "" {
+		opts.pingMode = pingModeRaw
+		p.config.pingMode = pingModeRaw
+	}
+	if err := checkPingPrivilege(opts.pingMode); err != nil {
+		return err
+	}
+	if p.probeType() == probeTypeTimestamp && isIPv6Target(opts.target) {
+		return fmt.Errorf("ICMP Timestamp probes are IPv4-only; target %s is IPv6", opts.target)
+	}
+	p.config.proto = protoForTarget(opts.target, opts.pingMode)
+	conn, err := dialICMP(p.config.proto, opts.bindAddr) // shared per-family conn, regardless if the packet is addressed to myself
+	if err != nil {
+		return err
+	}
+	addr, err := resolveTarget(opts.target, opts.pingMode) // resolved once, not on every packet sent
+	if err != nil {
+		return err
+	}
+	p.conn = conn
+	p.targetAddr = addr
+	p.recvCh, p.unsubscribe = conn.subscribe(addrIP(addr).String())
+	return nil
+}
+
+// isIPv6 reports whether this prober's target is an IPv6 address
+func (p *Prober) isIPv6() bool {
+	return isIPv6Target(p.config.target)
 }
 
 // TimeStamp - Base type for echo request/reply with timestamps
@@ -127,16 +386,42 @@ func (p *TimeStamp) Marshal(proto int) ([]byte, error) {
 	return b, nil
 }
 
+// icmp.ParseMessage has no built-in parser registered for type 13/14 (Timestamp), so it
+// hands back the body as an icmp.RawBody instead of a *TimeStamp. parseTimeStamp is the
+// wrapper unmarshaller that turns that raw body back into a *TimeStamp.
+func parseTimeStamp(body icmp.MessageBody) (*TimeStamp, bool) {
+	raw, ok := body.(*icmp.RawBody)
+	if !ok || len(raw.Data) < 16 {
+		return nil, false
+	}
+	b := raw.Data
+	return &TimeStamp{
+		ID:                 binary.BigEndian.Uint16(b[0:2]),
+		Seq:                binary.BigEndian.Uint16(b[2:4]),
+		OriginateTimestamp: binary.BigEndian.Uint32(b[4:8]),
+		ReceiveTimestamp:   binary.BigEndian.Uint32(b[8:12]),
+		TransmitTimestamp:  binary.BigEndian.Uint32(b[12:16]),
+	}, true
+}
+
 // construct the ICMP message and marshall it to bytes
 func (p *Prober) packetToSend(runId, seq uint16) []byte {
 	// todo: handle UDP
+	if p.probeType() == probeTypeTimestamp {
+		return p.timestampToSend(runId, seq)
+	}
+
+	var msgType icmp.Type = ipv4.ICMPTypeEcho
+	if p.isIPv6() {
+		msgType = ipv6.ICMPTypeEchoRequest
+	}
 	msg := &icmp.Message{
-		Type: ipv4.ICMPTypeEcho,
+		Type: msgType,
 		Code: 0,
 		Body: &icmp.Echo{
 			ID: int(runId),
 			Seq: int(seq),
-			Data: timeToBytes(time.Now()),
+			Data: encodeEchoData(runId, time.Now()),
 		},
 	}
 	bytes, err := msg.Marshal(nil)
@@ -147,27 +432,54 @@ func (p *Prober) packetToSend(runId, seq uint16) []byte {
 	return bytes
 }
 
-// unmarshall the bytes back to ICMP message
-func (p *Prober) packetToRecv(pktbuf []byte) (net.IP, *icmp.Message, error) {
-	n, sender, err := p.conn.ReadFrom(pktbuf)
-	if err != nil {
-		return nil, nil, err
-	}
-	// get the sender's IP address
-	// Since sender is an interface net.Addr, we have to cast it down to net.IPAddr/net.UDPAddr type to get the IP
-	var senderIP net.IP
-	switch sender := sender.(type) {
-	case *net.IPAddr:
-		senderIP = sender.IP
-	case *net.UDPAddr:
-		senderIP = sender.IP
+// encodeEchoData packs the echo send time together with runId as an explicit nonce.
+// In raw ping mode the kernel preserves the Echo ID field unchanged, so runId round-trips
+// there too; in UDP ping mode the kernel overwrites Echo ID with the ephemeral source
+// port, so this payload nonce is the only reliable way left to tell which run a reply
+// belongs to.
+func encodeEchoData(runId uint16, t time.Time) []byte {
+	data := make([]byte, timeBytesSize+2)
+	copy(data, timeToBytes(t))
+	binary.BigEndian.PutUint16(data[timeBytesSize:], runId)
+	return data
+}
+
+// decodeEchoData reverses encodeEchoData. ok is false if data is too short to contain
+// both fields (e.g. a reply from some other, non-goben ICMP echo exchange).
+func decodeEchoData(data []byte) (sendTime time.Time, nonce uint16, ok bool) {
+	if len(data) < timeBytesSize+2 {
+		return time.Time{}, 0, false
 	}
+	return bytesToTime(data[:timeBytesSize]), binary.BigEndian.Uint16(data[timeBytesSize:]), true
+}
 
-	msg, err := icmp.ParseMessage(protocolICMP, pktbuf[:n])
+// construct an ICMP Timestamp Request (type 13) and marshal it to bytes.
+// OriginateTimestamp is milliseconds-since-midnight UTC, per RFC 792; the remote host is
+// expected to echo it back unchanged in its reply alongside its own Receive/Transmit times.
+func (p *Prober) timestampToSend(runId, seq uint16) []byte {
+	msg := &icmp.Message{
+		Type: ipv4.ICMPTypeTimestamp,
+		Code: 0,
+		Body: &TimeStamp{
+			ID:                 runId,
+			Seq:                seq,
+			OriginateTimestamp: milliSinceMidnightUTC(),
+		},
+	}
+	bytes, err := msg.Marshal(nil)
 	if err != nil {
-		return nil, nil, err
+		// This should never happen
+		log.Panicf("Error marshalling the ICMP timestamp message. Err: %v\n", err)
 	}
-	return senderIP, msg, nil
+	return bytes
+}
+
+// milliSinceMidnightUTC returns the number of milliseconds since midnight UTC, the unit
+// ICMP Timestamp messages use for Originate/Receive/Transmit timestamps
+func milliSinceMidnightUTC() uint32 {
+	now := time.Now().UTC()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	return uint32(now.Sub(midnight).Milliseconds())
 }
 
 // send ICMP message
@@ -179,7 +491,7 @@ func (p *Prober) send(runID uint16, morePkts chan bool) {
 		if DEBUG {
 			log.Printf("Request to=%s id=%d seq=%d", target, runID, seq)
 		}
-		if _, err := p.conn.WriteTo(p.packetToSend(runID, seq), parseIP(target)); err != nil {
+		if _, err := p.conn.WriteTo(p.packetToSend(runID, seq), p.targetAddr); err != nil {
 			log.Println(err.Error())
 			continue
 		}
@@ -194,55 +506,90 @@ func (p *Prober) send(runID uint16, morePkts chan bool) {
 }
 
 // receive ICMP message and compute the measurements
-func (p *Prober) recv(runID uint16, morePkts chan bool) {
+// recv reads replies belonging to runID until every packet sent by send() has been
+// matched or lossTimeout elapses, and returns how many distinct replies were received
+func (p *Prober) recv(runID uint16, morePkts chan bool) int {
 	// keep track if the packet arrived has been received before
 	received := make(map[string]bool)
 	// a counter to make sure we read all packets arrived (including the outstanding
 	// ones after the sender has closed the connection)
 	outstandingPkts := 0
-	// the byte stream buffer
-	pktbuf := make([]byte, 1500)
 
+	deadline := time.NewTimer(lossTimeout())
+	defer deadline.Stop()
 	for {
 		if outstandingPkts == 0 {
 			if _, ok := <-morePkts; ok {
 				outstandingPkts++
 			} else {
-				return
+				return len(received)
 			}
 		}
-		senderIP, msg, err := p.packetToRecv(pktbuf)
-		if err != nil {
-			log.Printf("Unmarshalling icmp message Error: %s\n", err.Error())
-			if neterr, ok := err.(*net.OpError); ok && neterr.Timeout() {
-				return
-			}
+		var result recvResult
+		select {
+		case result = <-p.recvCh:
+		case <-deadline.C:
+			return len(received)
 		}
-		if (msg.Type != ipv4.ICMPTypeEchoReply) {
+		if result.err != nil {
+			log.Printf("Unmarshalling icmp message Error: %s\n", result.err.Error())
 			continue
 		}
-		target := senderIP.String()
-		echoMsg, ok := msg.Body.(*icmp.Echo)
-		if !ok {
-			log.Println("Got wrong packet in ICMP echo reply.") // should never happen
+		senderIP, msg := result.senderIP, result.msg
+		var id, seq int
+		var rtt, fwdDelay, revDelay time.Duration
+		var haveDelays bool
+		var nonce uint16
+		var haveNonce bool
+
+		switch {
+		case msg.Type == ipv4.ICMPTypeEchoReply || msg.Type == ipv6.ICMPTypeEchoReply:
+			echoMsg, ok := msg.Body.(*icmp.Echo)
+			if !ok {
+				log.Println("Got wrong packet in ICMP echo reply.") // should never happen
+				continue
+			}
+			sendTime, n, ok := decodeEchoData(echoMsg.Data)
+			if !ok {
+				log.Println("Got malformed ICMP echo reply.")
+				continue
+			}
+			id, seq = echoMsg.ID, int(echoMsg.Seq)
+			rtt = time.Since(sendTime)
+			nonce, haveNonce = n, true
+		case msg.Type == ipv4.ICMPTypeTimestampReply:
+			ts, ok := parseTimeStamp(msg.Body)
+			if !ok {
+				log.Println("Got malformed ICMP timestamp reply.")
+				continue
+			}
+			now := milliSinceMidnightUTC()
+			id, seq = int(ts.ID), int(ts.Seq)
+			rtt = time.Duration(now-ts.OriginateTimestamp) * time.Millisecond
+			fwdDelay = time.Duration(ts.ReceiveTimestamp-ts.OriginateTimestamp) * time.Millisecond
+			revDelay = time.Duration(now-ts.TransmitTimestamp) * time.Millisecond
+			haveDelays = true
+		default:
 			continue
 		}
-
-		// get rtt
-		rtt := time.Since(bytesToTime(echoMsg.Data))
+		target := senderIP.String()
 
 		// check if this packet belong to this run
-		if !matchPacket(runID, echoMsg.ID, echoMsg.Seq) && DEBUG {
-			log.Printf(
-				"Reply from=%s id=%d seq=%d rtt=%s Unmatched packet, probably from the last probe run.\n",
-				target, echoMsg.ID, echoMsg.Seq, rtt)
+		if !matchPacket(p.config.pingMode, runID, id, seq, nonce, haveNonce) {
+			if DEBUG {
+				log.Printf(
+					"Reply from=%s id=%d seq=%d rtt=%s Unmatched packet, probably from the last probe run.\n",
+					target, id, seq, rtt)
+			}
 			continue
 		}
 
 		// check if we have seen this packet before
-		pktID := fmt.Sprintf("%s_%d", target, echoMsg.Seq)
-		if received[pktID] && DEBUG {
-			log.Printf("Duplicate reply from=%s id=%d seq=%d rtt=%s\n", target, echoMsg.ID, echoMsg.Seq, rtt)
+		pktID := fmt.Sprintf("%s_%d", target, seq)
+		if received[pktID] {
+			if DEBUG {
+				log.Printf("Duplicate reply from=%s id=%d seq=%d rtt=%s\n", target, id, seq, rtt)
+			}
 			continue
 		}
 
@@ -250,11 +597,13 @@ func (p *Prober) recv(runID uint16, morePkts chan bool) {
 		if DEBUG {
 			log.Printf("RTT: src=%s, dst=%s, rtt=%s\n", p.config.source, target, rtt)
 		}
+		p.stats.recordRTT(rtt)
 
 		if p.config.csv != "" {
-			entry := make([]string, 2)
-			entry[0] = target
-			entry[1] = fmt.Sprintf("%v", rtt.Nanoseconds()) // round to millisecond
+			entry := []string{target, fmt.Sprintf("%v", rtt.Nanoseconds())}
+			if haveDelays {
+				entry = append(entry, fmt.Sprintf("%v", fwdDelay.Nanoseconds()), fmt.Sprintf("%v", revDelay.Nanoseconds()))
+			}
 			writingErr := p.result.Write(entry)
 			if writingErr != nil {
 				log.Panicf("Cannot write to csv file %v", writingErr.Error())
@@ -275,27 +624,62 @@ func (p *Prober) runProbe() {
 	wg.Add(1)
 	// morePtks is a channel used to let the receiver know when there are no more packets
 	morePkts := make(chan bool, int(pktsPerProbe))
+	var received int
 	go func() {
 		defer wg.Done()
-		p.recv(runID, morePkts)
+		received = p.recv(runID, morePkts)
 	}()
 	p.send(runID, morePkts)
 	wg.Wait()
+
+	p.stats.recordSent(pktsPerProbe)
+	if lost := pktsPerProbe - received; lost > 0 {
+		p.stats.recordLoss(uint64(lost))
+	}
 	if DEBUG {
 		log.Printf("The prober from host %s finished!\n", p.config.source)
 	}
 }
 
-// Start starts the prober and perform a probe for each probeInterval
+// Start probes the target once per probeInterval until ctx is cancelled.
 // Start must be called after Init() is called
-func (p *Prober) Start() {
+func (p *Prober) Start(ctx context.Context) {
 	if p.conn == nil {
 		log.Panicf("The prober from host %s is not properly initialized.\n", p.config.source)
 	}
-	defer p.conn.Close()
-	for range time.Tick(probeInterval) {
-		p.runProbe()
+	ticker := time.NewTicker(probeInterval)
+	defer ticker.Stop()
+	defer p.close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.runProbe()
+		}
+	}
+}
+
+// close releases the prober's own resources (the CSV writer/file, if any, and its
+// subscription on the shared conn). The underlying ICMP connection itself is shared
+// across Probers of the same address family (see icmpConns) and may still be in use by
+// others, so close does not close it.
+func (p *Prober) close() {
+	if p.unsubscribe != nil {
+		p.unsubscribe()
+	}
+	if p.file == nil {
+		return
 	}
+	if err := closeCSV(p.result, p.file); err != nil {
+		log.Printf("Cannot close csv file. %v\n", err.Error())
+	}
+}
+
+// Stats returns a snapshot of this prober's rolling latency/loss statistics
+func (p *Prober) Stats() Summary {
+	return p.stats.summary(p.config.target)
 }
 
 /* Helpers */
@@ -314,17 +698,34 @@ func (p *Prober) generateRunId() uint16 {
 }
 
 // check if the packet belong to a certain run
-func matchPacket(runId uint16, pktId, seq int) bool {
+// in UDP ping mode the kernel rewrites the echo ID to the ephemeral source port of the UDP
+// socket, so pktId can no longer be trusted. Echo replies carry an explicit nonce in their
+// payload instead (see encodeEchoData), which we match exactly; this also protects against
+// two Probers sharing one icmpConns socket and ticking on the same probeInterval, where the
+// old seq# run-prefix (runCnt, not random) collided between runs. Timestamp replies have no
+// such payload nonce, so UDP timestamp probes still fall back to the seq# run-prefix.
+func matchPacket(pingMode string, runId uint16, pktId, seq int, nonce uint16, haveNonce bool) bool {
+	if pingMode == pingModeUDP {
+		if haveNonce {
+			return runId == nonce
+		}
+		return runId>>8 == uint16(seq)>>8
+	}
 	return (runId == uint16(pktId)) && (runId>>8 == uint16(seq)>>8)
 }
 
-// parse host ip string to net.Addr
-func parseIP(host string) net.Addr {
-	// todo: handle udp
-	ip := net.ParseIP(host)
-	var addr net.Addr
-	addr = &net.IPAddr{IP: ip}
-	return addr
+// resolveTarget resolves host to the net.Addr send() writes every packet to. It is
+// called once by listen(), not per packet, so a transient resolver hiccup surfaces as a
+// normal Init() error rather than crashing an otherwise-healthy long-running prober.
+// Raw ping mode addresses the kernel ICMP socket directly with a *net.IPAddr; UDP ping
+// mode needs a *net.UDPAddr with port 0, letting the kernel assign the ephemeral source
+// port. Resolving through net.Resolve*Addr (rather than net.ParseIP) preserves an IPv6
+// zone ID (e.g. "fe80::1%eth0"), which Windows requires for a link-local target.
+func resolveTarget(host, pingMode string) (net.Addr, error) {
+	if pingMode == pingModeUDP {
+		return net.ResolveUDPAddr("udp", net.JoinHostPort(host, "0"))
+	}
+	return net.ResolveIPAddr("ip", host)
 }
 
 // serialize time to byte stream
@@ -367,3 +768,100 @@ func GetSourceIP() (string, error) {
 	}
 	return "", nil
 }
+
+/* Latency/loss aggregation */
+
+// latencyStats aggregates RTT samples for one target over a rolling window, exposing
+// the min/avg/max/stddev/loss-rate/percentile summary classic ping tools report
+type latencyStats struct {
+	mu      sync.Mutex
+	samples []time.Duration // rolling window of RTTs, oldest first
+	sent    uint64          // total probes sent
+	lost    uint64          // total probes considered lost
+}
+
+func newLatencyStats() *latencyStats {
+	return &latencyStats{samples: make([]time.Duration, 0, statsWindowSize)}
+}
+
+func (s *latencyStats) recordSent(n uint64) {
+	s.mu.Lock()
+	s.sent += n
+	s.mu.Unlock()
+}
+
+func (s *latencyStats) recordLoss(n uint64) {
+	s.mu.Lock()
+	s.lost += n
+	s.mu.Unlock()
+}
+
+func (s *latencyStats) recordRTT(rtt time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.samples = append(s.samples, rtt)
+	if over := len(s.samples) - statsWindowSize; over > 0 {
+		s.samples = s.samples[over:]
+	}
+}
+
+// Summary is a point-in-time snapshot of a target's latency/loss statistics
+type Summary struct {
+	Target  string
+	Min     time.Duration
+	Avg     time.Duration
+	Max     time.Duration
+	StdDev  time.Duration
+	P50     time.Duration
+	P95     time.Duration
+	P99     time.Duration
+	LossPct float64
+}
+
+func (s *latencyStats) summary(target string) Summary {
+	s.mu.Lock()
+	samples := append([]time.Duration(nil), s.samples...)
+	sent, lost := s.sent, s.lost
+	s.mu.Unlock()
+
+	sum := Summary{Target: target}
+	if sent > 0 {
+		sum.LossPct = float64(lost) / float64(sent) * 100
+	}
+	if len(samples) == 0 {
+		return sum
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+
+	sum.Min = samples[0]
+	sum.Max = samples[len(samples)-1]
+
+	var total time.Duration
+	for _, d := range samples {
+		total += d
+	}
+	sum.Avg = total / time.Duration(len(samples))
+
+	var variance float64
+	avg := float64(sum.Avg)
+	for _, d := range samples {
+		diff := float64(d) - avg
+		variance += diff * diff
+	}
+	variance /= float64(len(samples))
+	sum.StdDev = time.Duration(math.Sqrt(variance))
+
+	sum.P50 = percentile(samples, 0.50)
+	sum.P95 = percentile(samples, 0.95)
+	sum.P99 = percentile(samples, 0.99)
+	return sum
+}
+
+// percentile returns the p-th percentile (0..1) of an already-sorted duration slice
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(p * float64(len(sorted)-1))
+	return sorted[idx]
+}
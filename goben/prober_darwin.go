@@ -0,0 +1,11 @@
+//go:build darwin
+
+package main
+
+// checkPingPrivilege verifies the process can open the socket pingMode requires.
+// macOS allows unprivileged ICMP over a UDP socket (pingMode "udp") out of the box;
+// raw mode still needs root, but the kernel enforces that at dial time, so there is
+// nothing extra to check here.
+func checkPingPrivilege(pingMode string) error {
+	return nil
+}
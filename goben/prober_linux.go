@@ -0,0 +1,32 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// checkPingPrivilege verifies the process can open the socket pingMode requires.
+// Raw ICMP sockets on Linux need root or CAP_NET_RAW; unprivileged "udp" mode works
+// for any user whose UID falls in net.ipv4.ping_group_range (see icmp(7)).
+func checkPingPrivilege(pingMode string) error {
+	if pingMode != pingModeRaw || os.Geteuid() == 0 {
+		return nil
+	}
+	// CAP_NET_RAW is bit 13 of the effective capability set listed in /proc/self/status
+	if status, err := ioutil.ReadFile("/proc/self/status"); err == nil {
+		for _, line := range strings.Split(string(status), "\n") {
+			if hex := strings.TrimPrefix(line, "CapEff:"); hex != line {
+				caps, err := strconv.ParseUint(strings.TrimSpace(hex), 16, 64)
+				if err == nil && caps&(1<<13) != 0 {
+					return nil
+				}
+			}
+		}
+	}
+	return fmt.Errorf("pingMode=raw requires root or CAP_NET_RAW on Linux; try -pingMode=udp instead")
+}
@@ -0,0 +1,17 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// checkPingPrivilege is the fallback for platforms without a dedicated check (e.g.
+// FreeBSD): treat it like most other *nix systems, where raw ICMP needs root.
+func checkPingPrivilege(pingMode string) error {
+	if pingMode == pingModeRaw && os.Geteuid() != 0 {
+		return fmt.Errorf("pingMode=raw requires root on this platform; try -pingMode=udp instead")
+	}
+	return nil
+}
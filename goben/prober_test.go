@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEncodeDecodeEchoData(t *testing.T) {
+	sendTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	runId := uint16(0xabcd)
+
+	data := encodeEchoData(runId, sendTime)
+
+	gotTime, gotNonce, ok := decodeEchoData(data)
+	if !ok {
+		t.Fatalf("decodeEchoData(%x): ok = false, want true", data)
+	}
+	if gotNonce != runId {
+		t.Errorf("decodeEchoData(%x): nonce = %#x, want %#x", data, gotNonce, runId)
+	}
+	if !gotTime.Equal(sendTime) {
+		t.Errorf("decodeEchoData(%x): sendTime = %v, want %v", data, gotTime, sendTime)
+	}
+}
+
+func TestDecodeEchoDataTooShort(t *testing.T) {
+	for _, n := range []int{0, 1, timeBytesSize, timeBytesSize + 1} {
+		data := make([]byte, n)
+		if _, _, ok := decodeEchoData(data); ok {
+			t.Errorf("decodeEchoData(%d bytes): ok = true, want false", n)
+		}
+	}
+}
+
+func TestMatchPacketRaw(t *testing.T) {
+	cases := []struct {
+		name       string
+		runId, seq uint16
+		pktId      int
+		want       bool
+	}{
+		{"same run, matching id and seq prefix", 0x0100, 0x0105, 0x0100, true},
+		{"wrong id", 0x0100, 0x0105, 0x0200, false},
+		{"wrong seq prefix", 0x0100, 0x0205, 0x0100, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := matchPacket(pingModeRaw, c.runId, c.pktId, int(c.seq), 0, false)
+			if got != c.want {
+				t.Errorf("matchPacket(raw, runId=%#x, pktId=%#x, seq=%#x) = %v, want %v",
+					c.runId, c.pktId, c.seq, got, c.want)
+			}
+		})
+	}
+}
+
+func TestMatchPacketUDP(t *testing.T) {
+	// Two Probers with different runIds but the same high byte (runCnt), the case that
+	// broke the old seq#-prefix-only UDP matching once chunk0-4 started running several
+	// Probers concurrently on one shared conn (see chunk0-2's fix).
+	const runIdA, runIdB = 0x01aa, 0x01bb
+
+	if !matchPacket(pingModeUDP, runIdA, 0 /* pktId is untrustworthy in UDP mode */, 0x0105, runIdA, true) {
+		t.Error("matchPacket(udp): own nonce should match")
+	}
+	if matchPacket(pingModeUDP, runIdA, 0, 0x0105, runIdB, true) {
+		t.Error("matchPacket(udp): another Prober's nonce must not match, even with the same runCnt high byte")
+	}
+
+	// Timestamp replies carry no payload nonce; UDP mode falls back to the seq# prefix.
+	if !matchPacket(pingModeUDP, runIdA, 0, 0x01ff, 0, false) {
+		t.Error("matchPacket(udp, no nonce): matching seq# prefix should match")
+	}
+	if matchPacket(pingModeUDP, runIdA, 0, 0x02ff, 0, false) {
+		t.Error("matchPacket(udp, no nonce): mismatched seq# prefix must not match")
+	}
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package main
+
+import "fmt"
+
+// checkPingPrivilege verifies the process can open the socket pingMode requires.
+// Windows has no unprivileged ICMP socket equivalent to Linux's ping_group_range, so
+// raw ICMP always requires an elevated (Administrator) process, and unprivileged UDP
+// ping mode isn't available at all. Elevation is not checked here: a non-elevated
+// process in raw mode fails when the socket is opened.
+func checkPingPrivilege(pingMode string) error {
+	if pingMode == pingModeUDP {
+		return fmt.Errorf("pingMode=udp is not supported on Windows; use the default raw mode from an elevated process")
+	}
+	return nil
+}